@@ -12,199 +12,431 @@
 //
 // The playload is encoded by xor'ing the two half of the data and
 // appending a checksum to the payload, so that errors can be detected
-// and recovered automatically.
+// and recovered automatically. The checksum algorithm is pluggable
+// through Options and Algorithm; the default, Adler32, matches the
+// package's original behaviour, but cryptographic algorithms are
+// available for payloads that must be defended against adversarial
+// bitrot rather than just accidental corruption. Each block records
+// the algorithm it was written with, so Decode auto-detects it and
+// needs no help from the caller.
 package erasure
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"hash"
 	"hash/adler32"
-	"unsafe"
+	"hash/crc32"
+
+	"github.com/minio/highwayhash"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Algorithm identifies a hash function used to detect corruption in an
+// encoded block. It plays the same role as crypto.Hash: New returns a
+// fresh hash.Hash, and Size reports the number of checksum bytes it
+// produces without requiring callers to instantiate one.
+type Algorithm uint8
+
+const (
+	// Adler32 is fast but only catches accidental corruption; it does
+	// nothing against an adversary able to craft a colliding payload.
+	// It is the package's original algorithm and Options' zero value.
+	Adler32 Algorithm = 1 + iota
+	// CRC32C uses the Castagnoli polynomial, which most modern CPUs
+	// accelerate in hardware, giving Adler32-like speed with a better
+	// distributed checksum.
+	CRC32C
+	// BLAKE2b256 is a cryptographic hash truncated to 256 bits. Use it
+	// when blocks may be tampered with, not just corrupted in transit.
+	BLAKE2b256
+	// SHA256 is the standard library's cryptographic hash.
+	SHA256
+	// HighwayHash256 is a keyed cryptographic hash tuned for high
+	// throughput on vectorized CPUs.
+	HighwayHash256
 )
 
-func chcksum(data []byte) uint32 {
-	return adler32.Checksum(data)
+// highwayHashKey is fixed rather than caller-supplied: HighwayHash256 is
+// used here for its speed and collision resistance against bitrot, not
+// as a MAC, so there is no secret to protect.
+var highwayHashKey = make([]byte, 32)
+
+// New returns a fresh hash.Hash implementing a.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case Adler32:
+		return adler32.New()
+	case CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case BLAKE2b256:
+		h, err := blake2b.New256(nil)
+		if err != nil {
+			panic("erasure: blake2b.New256: " + err.Error())
+		}
+		return h
+	case SHA256:
+		return sha256.New()
+	case HighwayHash256:
+		h, err := highwayhash.New(highwayHashKey)
+		if err != nil {
+			panic("erasure: highwayhash.New: " + err.Error())
+		}
+		return h
+	default:
+		panic(fmt.Sprintf("erasure: unknown Algorithm %d", uint8(a)))
+	}
+}
+
+// Size reports the number of bytes a checksum produced by a occupies.
+func (a Algorithm) Size() int {
+	return a.New().Size()
+}
+
+// String returns a's name.
+func (a Algorithm) String() string {
+	switch a {
+	case Adler32:
+		return "adler32"
+	case CRC32C:
+		return "crc32c"
+	case BLAKE2b256:
+		return "blake2b-256"
+	case SHA256:
+		return "sha-256"
+	case HighwayHash256:
+		return "highwayhash-256"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", uint8(a))
+	}
+}
+
+// algorithmFromByte validates that b identifies a known Algorithm.
+func algorithmFromByte(b byte) (Algorithm, error) {
+	switch a := Algorithm(b); a {
+	case Adler32, CRC32C, BLAKE2b256, SHA256, HighwayHash256:
+		return a, nil
+	default:
+		return 0, fmt.Errorf("erasure: unknown algorithm identifier %d", b)
+	}
+}
+
+// Options configures optional behaviour of EncodeWith. The zero value
+// selects Adler32, matching the package's original behaviour.
+type Options struct {
+	// Algo is the hash algorithm used to detect corrupted blocks.
+	Algo Algorithm
+}
+
+func (o Options) algo() Algorithm {
+	if o.Algo == 0 {
+		return Adler32
+	}
+	return o.Algo
+}
+
+func chcksum(algo Algorithm, data []byte) []byte {
+	h := algo.New()
+	h.Write(data)
+	return h.Sum(nil)
 }
 
 // Encode breaks data into 3 packets, needing only 2 of them
 // to reconstruct the original content. The packets can be decoded
-// in any order.
+// in any order. It uses Adler32 to detect corrupted blocks; use
+// EncodeWith to choose a different Algorithm.
 func Encode(data []byte) (block1, block2, block3 []byte, err error) {
+	return EncodeWith(data, Options{})
+}
+
+// EncodeWith behaves like Encode but lets callers choose, via
+// opts.Algo, the hash algorithm used to detect corrupted blocks.
+func EncodeWith(data []byte, opts Options) (block1, block2, block3 []byte, err error) {
+	algo := opts.algo()
+	sumSize := uint64(algo.Size())
 
 	alen := uint64(len(data) / 2)
 
 	blen := alen
 	blocklen := alen
-	if (len(data)/2)%2 != 0 {
+	if len(data)%2 != 0 {
+		// data didn't split evenly: B carries the odd byte out
 		blen++
 	}
-	// 1 byte for order, 8 bytes for alen/blen, 4 bytes for crc32
-	blocklen = blen + (1 + 8 + 4)
+	// 1 byte for order, 1 byte for algorithm, 8 bytes for alen/blen, sumSize bytes for the checksum
+	blocklen = blen + (1 + 1 + 8 + sumSize)
 
 	// A block looks like...
-	// 1            : the order of the block
-	// 1 to 9       : the length of the block
-	// 9 to len     : the data of the block
-	// 9+len to end : the checksum of the len+data
+	// 1             : the order of the block
+	// 1 to 2        : the algorithm the block was checksummed with
+	// 2 to 10       : the length of the block
+	// 10 to len     : the data of the block
+	// len to end    : the checksum of the len+data
 
 	a := make([]byte, blocklen)
-	a[0] = byte(1)                 // write the order
-	uint64b(a[1:9], alen)          // write the length
-	copy(a[9:9+alen], data[:alen]) // write the data from 0 to alen
-	asum := chcksum(a[:blocklen-4])
-	uint32b(a[blocklen-4:], asum) // write the chsksum of alen+a
+	a[0] = byte(1)                   // write the order
+	a[1] = byte(algo)                // write the algorithm
+	uint64b(a[2:10], alen)           // write the length
+	copy(a[10:10+alen], data[:alen]) // write the data from 0 to alen
+	asum := chcksum(algo, a[:blocklen-sumSize])
+	copy(a[blocklen-sumSize:], asum) // write the chsksum of alen+a
 
 	b := make([]byte, blocklen)
-	b[0] = byte(2)                 // write the order
-	uint64b(b[1:9], blen)          // write the length
-	copy(b[9:9+blen], data[alen:]) // write the data from alen to blen
-	bsum := chcksum(b[:9+blen])
-	uint32b(b[blocklen-4:], bsum) // write the chsksum of blen+b
+	b[0] = byte(2)                   // write the order
+	b[1] = byte(algo)                // write the algorithm
+	uint64b(b[2:10], blen)           // write the length
+	copy(b[10:10+blen], data[alen:]) // write the data from alen to blen
+	bsum := chcksum(algo, b[:10+blen])
+	copy(b[blocklen-sumSize:], bsum) // write the chsksum of blen+b
 
 	x := make([]byte, blocklen)
-	// don't need to write length or order (order == 3 because 1^2)
-	xor(x[:9+blen], a[:9+blen], b[:9+blen]) // xor a with b
-	xsum := chcksum(x[:blocklen-4])
-	uint32b(x[blocklen-4:], xsum) // write the chsksum of the xlen+xor
+	// don't need to write length or order (order == 3 because 1^2), but
+	// the algorithm byte does need writing: a and b share the same
+	// algorithm, so xor'ing it in would cancel it out to 0.
+	xor(x[:10+blen], a[:10+blen], b[:10+blen]) // xor a with b
+	x[1] = byte(algo)
+	xsum := chcksum(algo, x[:blocklen-sumSize])
+	copy(x[blocklen-sumSize:], xsum) // write the chsksum of the xlen+xor
 
 	return a, b, x, nil
 }
 
-// Decode the original data from the 3 packets it was encoded with. The blocks
-// can come in any order.
-//
-// The current implementation does not repair blocks that are detected
-// broken. If a block was broken, it will be returned along with the payload.
-// A user can Encode again the payload to repair the broken block and
-// refresh it.
-func Decode(block1, block2, block3 []byte) (result, broken []byte, err error) {
-
-	// TODO(antoine): repair broken blocks so they can be refreshed.
-	// right now the proper answer is given, but the encoded block
-	// is not repaired, only the data necessary to make the payload is
+// classified is the result of validating and sorting block1, block2
+// and block3 into their data-A, data-B and parity-X roles, shared by
+// Decode and DecodeRepair.
+type classified struct {
+	blockA, blockB, blockX []byte
+	agood, bgood, xgood    bool
+	alen, blen             uint64
+	aalgo, balgo, xalgo    Algorithm
+	broken                 []byte // original bytes of whichever input block failed validation; block3 when all 3 are valid
+}
 
+// classifyBlocks validates block1, block2 and block3 and sorts them
+// into their A/B/X roles by the position each declares in its own
+// header. It errors if fewer than 2 of the 3 are valid.
+func classifyBlocks(block1, block2, block3 []byte) (classified, error) {
 	if len(block1) != len(block2) && len(block2) != len(block3) {
-		return nil, nil, fmt.Errorf("blocks are of different sizes")
+		return classified{}, fmt.Errorf("blocks are of different sizes")
 	}
-	blocklen := len(block1)
 
-	pos1, len1, good1 := validate(block1)
-	pos2, len2, good2 := validate(block2)
-	pos3, len3, good3 := validate(block3)
+	pos1, algo1, len1, good1 := validate(block1)
+	pos2, algo2, len2, good2 := validate(block2)
+	pos3, algo3, len3, good3 := validate(block3)
 
+	var c classified
 	switch {
 	case good1 && good2:
-		broken = block3
+		c.broken = block3
 	case good1 && good3:
-		broken = block2
+		c.broken = block2
 	case good2 && good3:
-		broken = block1
+		c.broken = block1
 	}
 
-	var (
-		blockA, blockB, blockX []byte
-		agood, bgood, xgood    bool
-		alen, blen             uint64
-	)
-
 	switch pos1 {
 	case 0:
 	case 1:
-		blockA, agood, alen = block1, good1, len1
+		c.blockA, c.agood, c.alen, c.aalgo = block1, good1, len1, algo1
 	case 2:
-		blockB, bgood, blen = block1, good1, len1
+		c.blockB, c.bgood, c.blen, c.balgo = block1, good1, len1, algo1
 	case 3:
-		blockX, xgood = block1, good1
+		c.blockX, c.xgood, c.xalgo = block1, good1, algo1
 	}
 
 	switch pos2 {
 	case 0:
 	case 1:
-		blockA, agood, alen = block2, good2, len2
+		c.blockA, c.agood, c.alen, c.aalgo = block2, good2, len2, algo2
 	case 2:
-		blockB, bgood, blen = block2, good2, len2
+		c.blockB, c.bgood, c.blen, c.balgo = block2, good2, len2, algo2
 	case 3:
-		blockX, xgood = block2, good2
+		c.blockX, c.xgood, c.xalgo = block2, good2, algo2
 	}
 
 	switch pos3 {
 	case 0:
 	case 1:
-		blockA, agood, alen = block3, good3, len3
+		c.blockA, c.agood, c.alen, c.aalgo = block3, good3, len3, algo3
 	case 2:
-		blockB, bgood, blen = block3, good3, len3
+		c.blockB, c.bgood, c.blen, c.balgo = block3, good3, len3, algo3
 	case 3:
-		blockX, xgood = block3, good3
+		c.blockX, c.xgood, c.xalgo = block3, good3, algo3
 	}
 
 	// bad cases first
-	if !agood && !bgood {
-		return nil, nil, fmt.Errorf("block A and B are bad, can't reconstruct")
+	if !c.agood && !c.bgood {
+		return classified{}, fmt.Errorf("block A and B are bad, can't reconstruct")
 	}
-	if !agood && !xgood {
-		return nil, nil, fmt.Errorf("block A and X are bad, can't reconstruct")
+	if !c.agood && !c.xgood {
+		return classified{}, fmt.Errorf("block A and X are bad, can't reconstruct")
 	}
-	if !bgood && !xgood {
-		return nil, nil, fmt.Errorf("block B and X are bad, can't reconstruct")
+	if !c.bgood && !c.xgood {
+		return classified{}, fmt.Errorf("block B and X are bad, can't reconstruct")
 	}
 
-	if agood && bgood && xgood {
-		// don't need to reconstruct
-		a := blockA[9 : 9+alen]
-		b := blockB[9 : 9+blen]
-		return append(a, b...), nil, nil
-	}
+	return c, nil
+}
+
+// concat returns a fresh slice holding a followed by b. A plain
+// append(a, b...) reuses a's spare capacity when there's enough of it,
+// which silently corrupts whatever a's backing array holds past its
+// length - in Decode and DecodeRepair, a is often a sub-slice of a
+// caller-owned input block with its trailing checksum bytes sitting
+// right there as spare capacity. concat always allocates, so it's
+// safe to use on a slice the caller still holds a reference to.
+func concat(a, b []byte) []byte {
+	result := make([]byte, 0, len(a)+len(b))
+	result = append(result, a...)
+	return append(result, b...)
+}
 
-	if agood && bgood && !xgood {
-		// TODO(antoine): repair blockC
-		a := blockA[9 : 9+alen]
-		b := blockB[9 : 9+blen]
+// rebuildXOR reconstructs the block at the given position (1 for A, 2
+// for B, 3 for X) from the other two full blocks of an encode trio.
+// XOR is its own inverse, so x = a^b, a = b^x and b = a^x all use the
+// same operation; the result is written with a fresh order byte,
+// algorithm byte and checksum, making it a complete, valid block
+// rather than just the bytes needed to recover the payload.
+//
+// known1 and known2 are trimmed using their own declared algorithms
+// (algo1, algo2) rather than a shared one, since the two survivors may
+// have been written with different Algorithms; outAlgo is the
+// Algorithm the rebuilt block itself is written with.
+func rebuildXOR(known1 []byte, algo1 Algorithm, known2 []byte, algo2 Algorithm, position uint8, outAlgo Algorithm) []byte {
+	k1 := known1[:len(known1)-algo1.Size()]
+	k2 := known2[:len(known2)-algo2.Size()]
+	n := len(k1)
+
+	rebuilt := make([]byte, n+outAlgo.Size())
+	xor(rebuilt[:n], k1, k2)
+	rebuilt[0] = byte(position)
+	rebuilt[1] = byte(outAlgo)
+	sum := chcksum(outAlgo, rebuilt[:n])
+	copy(rebuilt[n:], sum)
+	return rebuilt
+}
+
+// Decode the original data from the 3 packets it was encoded with. The blocks
+// can come in any order, and each is validated with the Algorithm it
+// declares in its own header, so blocks written with different
+// algorithms can still be decoded together.
+//
+// Decode does not repair a block that is detected broken: it is
+// returned as-is via broken, and the payload is reconstructed from the
+// other two. Use DecodeRepair instead if you also want the broken
+// block rebuilt so you can write the fix back to storage.
+func Decode(block1, block2, block3 []byte) (result, broken []byte, err error) {
+	c, err := classifyBlocks(block1, block2, block3)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		return append(a, b...), broken, nil
+	if c.agood && c.bgood && c.xgood {
+		// don't need to reconstruct
+		a := c.blockA[10 : 10+c.alen]
+		b := c.blockB[10 : 10+c.blen]
+		return concat(a, b), nil, nil
 	}
 
-	if bgood && xgood {
-		// TODO(antoine): repair blockA
+	if c.agood && c.bgood && !c.xgood {
+		a := c.blockA[10 : 10+c.alen]
+		b := c.blockB[10 : 10+c.blen]
+		return concat(a, b), c.broken, nil
+	}
 
-		// reconstruct A from B and X
-		a := make([]byte, blocklen-4)
-		b := blockB[:blocklen-4]
-		x := blockX[:blocklen-4]
+	if c.bgood && c.xgood {
+		// reconstruct A from B and X, each trimmed by its own declared
+		// algorithm's checksum size, since B and X may have been written
+		// with different Algorithms.
+		b := c.blockB[:len(c.blockB)-c.balgo.Size()]
+		x := c.blockX[:len(c.blockX)-c.xalgo.Size()]
+		a := make([]byte, len(b))
 
 		xor(a, b, x)
 		// read A's len
-		alen := buint64(a[1:9])
+		alen := buint64(a[2:10])
 
-		return append(
-			a[9:9+alen],
-			b[9:9+blen]...,
-		), broken, nil
+		return concat(a[10:10+alen], b[10:10+c.blen]), c.broken, nil
 	}
 
-	// TODO(antoine): repair blockB
-
-	// last case possible, B is broken
-	// reconstruct B from A and X
-	b := make([]byte, blocklen-4)
-	a := blockA[:blocklen-4]
-	x := blockX[:blocklen-4]
+	// last case possible, B is broken: reconstruct B from A and X, each
+	// trimmed by its own declared algorithm's checksum size.
+	a := c.blockA[:len(c.blockA)-c.aalgo.Size()]
+	x := c.blockX[:len(c.blockX)-c.xalgo.Size()]
+	b := make([]byte, len(a))
 
 	xor(b, a, x)
 	// read B's len
-	blen = buint64(b[1:9])
-	return append(
-		a[9:9+alen],
-		b[9:9+blen]...,
-	), broken, nil
+	blen := buint64(b[2:10])
+	// a is a sub-slice of the caller's own blockA, so concat (not plain
+	// append) to avoid writing b's bytes into blockA's trailing checksum.
+	return concat(a[10:10+c.alen], b[10:10+blen]), c.broken, nil
+}
+
+// DecodeRepair behaves like Decode, but also rebuilds whichever block
+// was found broken, bit-for-bit, and returns all 3 valid blocks so a
+// caller can write the fix back to storage directly. This avoids the
+// round-trip of calling Decode and then re-Encoding the whole payload
+// just to refresh one shard.
+//
+// repaired holds the order-1 ("A"), order-2 ("B") and order-3 ("X")
+// blocks, in that order, regardless of the order block1, block2 and
+// block3 were passed in. When no repair was needed, repaired is simply
+// those same 3 blocks sorted into that order.
+func DecodeRepair(block1, block2, block3 []byte) (data []byte, repaired [3][]byte, err error) {
+	c, err := classifyBlocks(block1, block2, block3)
+	if err != nil {
+		return nil, repaired, err
+	}
+
+	switch {
+	case c.agood && c.bgood && c.xgood:
+		repaired = [3][]byte{c.blockA, c.blockB, c.blockX}
+
+	case c.agood && c.bgood:
+		repaired = [3][]byte{c.blockA, c.blockB, rebuildXOR(c.blockA, c.aalgo, c.blockB, c.balgo, 3, c.aalgo)}
+
+	case c.bgood && c.xgood:
+		repaired = [3][]byte{rebuildXOR(c.blockB, c.balgo, c.blockX, c.xalgo, 1, c.balgo), c.blockB, c.blockX}
+
+	default: // c.agood && c.xgood
+		repaired = [3][]byte{c.blockA, rebuildXOR(c.blockA, c.aalgo, c.blockX, c.xalgo, 2, c.aalgo), c.blockX}
+	}
+
+	alen := buint64(repaired[0][2:10])
+	blen := buint64(repaired[1][2:10])
+	a := repaired[0][10 : 10+alen]
+	b := repaired[1][10 : 10+blen]
+	// a and b are sub-slices of the blocks repaired is about to return
+	// as valid; concat (not plain append) so building the returned data
+	// never writes into their trailing checksum bytes.
+	return concat(a, b), repaired, nil
 }
 
-func validate(block []byte) (uint8, uint64, bool) {
+// validate checks block's checksum using the Algorithm identified in
+// its own header, returning the block's order, that Algorithm, its
+// declared data length, and whether the checksum matched.
+func validate(block []byte) (order uint8, algo Algorithm, length uint64, ok bool) {
+	if len(block) < 10 {
+		return 0, 0, 0, false
+	}
+	algo, err := algorithmFromByte(block[1])
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	sumSize := algo.Size()
 	l := len(block)
-	expected := buint32(block[l-4 : l])
-	actual := chcksum(block[:l-4])
-	if expected != actual {
-		return 0, 0, false
+	if l < 10+sumSize {
+		return 0, 0, 0, false
+	}
+	expected := block[l-sumSize:]
+	actual := chcksum(algo, block[:l-sumSize])
+	if !bytes.Equal(expected, actual) {
+		return 0, 0, 0, false
 	}
-	return block[0], buint64(block[1:9]), true
+	return block[0], algo, buint64(block[2:10]), true
 }
 
 func uint32b(dst []byte, u uint32) {
@@ -263,12 +495,15 @@ func xor(dst, blockA, blockB []byte) {
 }
 
 func fast64bitsXor(dst, blockA, blockB []byte) {
-	dst64 := *(*[]uint64)(unsafe.Pointer(&dst))
-	blockA64 := *(*[]uint64)(unsafe.Pointer(&blockA))
-	blockB64 := *(*[]uint64)(unsafe.Pointer(&blockB))
-
+	// previously reinterpreted the byte slices as []uint64 via
+	// unsafe.Pointer slice-header aliasing, which is undefined behaviour
+	// under the Go memory model (the backing arrays aren't guaranteed to
+	// be uint64-aligned). binary.LittleEndian does the same 8-bytes-at-a-
+	// time work without the aliasing.
 	n := len(dst) / 8
 	for i := 0; i < n; i++ {
-		dst64[i] = blockA64[i] ^ blockB64[i]
+		off := i * 8
+		word := binary.LittleEndian.Uint64(blockA[off:]) ^ binary.LittleEndian.Uint64(blockB[off:])
+		binary.LittleEndian.PutUint64(dst[off:], word)
 	}
 }
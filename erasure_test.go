@@ -1,6 +1,7 @@
 package erasure
 
 import (
+	"bytes"
 	"github.com/dustin/randbo"
 	"math/rand"
 	"testing"
@@ -67,6 +68,163 @@ func TestXOR(t *testing.T) {
 	}
 }
 
+func TestEncodeWithAlgorithms(t *testing.T) {
+	algos := []Algorithm{Adler32, CRC32C, BLAKE2b256, SHA256, HighwayHash256}
+	data := []byte("hello, there, this is a payload for every algorithm")
+
+	for _, algo := range algos {
+		blockA, blockB, blockX, err := EncodeWith(data, Options{Algo: algo})
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		if blockA[1] != byte(algo) {
+			t.Fatalf("%s: block header does not record the algorithm", algo)
+		}
+
+		gotData, broken, err := Decode(blockA, blockB, blockX)
+		if err != nil {
+			t.Fatalf("%s: couldn't decode: %v", algo, err)
+		}
+		if broken != nil {
+			t.Fatalf("%s: should not have found a broken block", algo)
+		}
+		if string(gotData) != string(data) {
+			t.Errorf("%s: want %q got %q", algo, data, gotData)
+		}
+	}
+}
+
+func TestDecodeDoesNotMutateInputBlocks(t *testing.T) {
+	// A short payload with a large-checksum algorithm: alen/blen is well
+	// under sumSize, so a naive append(a, b...) reusing a's spare
+	// capacity (the bytes of a's own trailing checksum) would silently
+	// corrupt the block a came from.
+	data := []byte("hello, there")
+	algo := SHA256
+
+	blockA, blockB, blockX, err := EncodeWith(data, Options{Algo: algo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantA := append([]byte(nil), blockA...)
+	wantB := append([]byte(nil), blockB...)
+	wantX := append([]byte(nil), blockX...)
+
+	if _, _, err := Decode(blockA, blockB, blockX); err != nil {
+		t.Fatalf("couldn't decode: %v", err)
+	}
+	if !bytes.Equal(blockA, wantA) {
+		t.Errorf("Decode mutated blockA: got %x want %x", blockA, wantA)
+	}
+	if !bytes.Equal(blockB, wantB) {
+		t.Errorf("Decode mutated blockB: got %x want %x", blockB, wantB)
+	}
+	if !bytes.Equal(blockX, wantX) {
+		t.Errorf("Decode mutated blockX: got %x want %x", blockX, wantX)
+	}
+}
+
+func TestDecodeRepairDoesNotCorruptRepairedBlocks(t *testing.T) {
+	// Same short-payload, large-checksum setup as
+	// TestDecodeDoesNotMutateInputBlocks: alen/blen is well under
+	// sumSize, so assembling the returned payload with a naive
+	// append(a, b...) would silently corrupt whichever repaired block a
+	// came from - the exact block DecodeRepair is telling the caller is
+	// now safe to write back to storage.
+	data := []byte("hello, there")
+	blockA, blockB, blockX, err := EncodeWith(data, Options{Algo: SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, repaired, err := DecodeRepair(blockA, blockB, blockX)
+	if err != nil {
+		t.Fatalf("DecodeRepair: %v", err)
+	}
+	_, broken, err := Decode(repaired[0], repaired[1], repaired[2])
+	if err != nil {
+		t.Fatalf("repaired blocks failed to decode: %v", err)
+	}
+	if broken != nil {
+		t.Errorf("DecodeRepair returned a block that fails its own checksum: %x broken", broken)
+	}
+}
+
+func TestDecodeMixedAlgorithms(t *testing.T) {
+	// B and X come from separate EncodeWith calls using different
+	// Algorithms. Since both calls see the same data, their header+data
+	// portions line up bit-for-bit except for the algorithm byte; the
+	// trailing checksum differs in algorithm and size. Decode must trim each surviving block by
+	// its own declared algorithm, not one borrowed from its sibling.
+	data := []byte("hello, there, this is a payload for every algorithm")
+
+	_, blockB, _, err := EncodeWith(data, Options{Algo: SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, blockX, err := EncodeWith(data, Options{Algo: Adler32})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A is unavailable; stand in with a block of zeroes as long as B, so
+	// it passes the block-size check and then fails validation.
+	brokenA := make([]byte, len(blockB))
+
+	gotData, broken, err := Decode(brokenA, blockB, blockX)
+	if err != nil {
+		t.Fatalf("couldn't decode: %v", err)
+	}
+	if broken == nil {
+		t.Fatalf("should have found a broken block")
+	}
+	if string(gotData) != string(data) {
+		t.Errorf("want %q got %q", data, gotData)
+	}
+}
+
+func TestDecodeRepair(t *testing.T) {
+	data := []byte(string(nBytes(1 << 10)))
+	blockA, blockB, blockX, err := Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name    string
+		A, B, X []byte
+	}{
+		{Name: "A broken", A: flipbits(blockA, 8), B: blockB, X: blockX},
+		{Name: "B broken", A: blockA, B: flipbits(blockB, 8), X: blockX},
+		{Name: "X broken", A: blockA, B: blockB, X: flipbits(blockX, 8)},
+	}
+
+	want := [3][]byte{blockA, blockB, blockX}
+	for _, tt := range cases {
+		gotData, repaired, err := DecodeRepair(tt.A, tt.B, tt.X)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.Name, err)
+		}
+		if string(gotData) != string(data) {
+			t.Errorf("%s: want %q got %q", tt.Name, data, gotData)
+		}
+		for i := range repaired {
+			if string(repaired[i]) != string(want[i]) {
+				t.Errorf("%s: repaired[%d] does not match the original block it was rebuilding", tt.Name, i)
+			}
+		}
+
+		// the repaired blocks must themselves decode cleanly with no broken block left
+		_, broken, err := Decode(repaired[0], repaired[1], repaired[2])
+		if err != nil {
+			t.Fatalf("%s: repaired blocks failed to decode: %v", tt.Name, err)
+		}
+		if broken != nil {
+			t.Errorf("%s: repaired blocks should have no broken block left", tt.Name)
+		}
+	}
+}
+
 func TestCantDecodeBadBlocks(t *testing.T) {
 	block1, block2, block3 := make([]byte, 1), make([]byte, 2), make([]byte, 3)
 	_, _, err := Decode(block1, block2, block3)
@@ -0,0 +1,154 @@
+package erasure
+
+// This file implements arithmetic over GF(2^8) using the standard
+// Reed-Solomon field with generator polynomial x^8+x^4+x^3+x^2+1 (0x11d).
+// Log/exp tables turn multiplication and division into table lookups,
+// which is what makes the Vandermonde matrix math in reedsolomon.go
+// fast enough to use per-byte.
+
+const gfPoly = 0x11d
+
+var (
+	gfExp [512]byte // exp[i] == exp[i+255], avoids a modulo on lookup
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// gfMul multiplies a and b in GF(2^8).
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfDiv divides a by b in GF(2^8). b must not be zero.
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+255-int(gfLog[b]))%255]
+}
+
+// gfPow raises a to the n-th power in GF(2^8). a^0 is 1 even for a == 0,
+// matching the usual convention and what the Vandermonde matrix in
+// reedsolomon.go needs for its first column.
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// gfMatrix is a dense matrix over GF(2^8), stored row-major.
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// gfVandermonde builds a rows x cols Vandermonde matrix over GF(2^8),
+// where entry (r, c) is r^c.
+func gfVandermonde(rows, cols int) gfMatrix {
+	m := newGFMatrix(rows, cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+// multiply returns m*other.
+func (m gfMatrix) multiply(other gfMatrix) gfMatrix {
+	rows, inner, cols := len(m), len(other), len(other[0])
+	result := newGFMatrix(rows, cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			var sum byte
+			for k := 0; k < inner; k++ {
+				sum ^= gfMul(m[r][k], other[k][c])
+			}
+			result[r][c] = sum
+		}
+	}
+	return result
+}
+
+// subMatrixRows returns a new matrix built from the given rows of m, in order.
+func (m gfMatrix) subMatrixRows(rows []int) gfMatrix {
+	sub := make(gfMatrix, len(rows))
+	for i, r := range rows {
+		sub[i] = m[r]
+	}
+	return sub
+}
+
+// invert computes the inverse of a square matrix over GF(2^8) using
+// Gauss-Jordan elimination, augmenting m with the identity matrix.
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n, 2*n)
+	for r := 0; r < n; r++ {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		if work[col][col] == 0 {
+			swapped := false
+			for r := col + 1; r < n; r++ {
+				if work[r][col] != 0 {
+					work[col], work[r] = work[r], work[col]
+					swapped = true
+					break
+				}
+			}
+			if !swapped {
+				return nil, errMatrixSingular
+			}
+		}
+
+		inv := gfDiv(1, work[col][col])
+		for c := 0; c < 2*n; c++ {
+			work[col][c] = gfMul(work[col][c], inv)
+		}
+
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			for c := 0; c < 2*n; c++ {
+				work[r][c] ^= gfMul(factor, work[col][c])
+			}
+		}
+	}
+
+	result := newGFMatrix(n, n)
+	for r := 0; r < n; r++ {
+		copy(result[r], work[r][n:])
+	}
+	return result, nil
+}
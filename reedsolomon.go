@@ -0,0 +1,164 @@
+package erasure
+
+import "fmt"
+
+var errMatrixSingular = fmt.Errorf("erasure: encoding submatrix is not invertible")
+
+// ReedSolomon encodes a payload into a configurable number of data and
+// parity shards, generalizing the package's fixed 2-of-3 XOR scheme:
+// any dataShards of the dataShards+parityShards shards it produces are
+// sufficient to reconstruct the original payload.
+//
+// It works over GF(2^8) using an (dataShards+parityShards) x dataShards
+// encoding matrix whose top dataShards rows are the identity (so data
+// shards pass through unchanged) and whose bottom parityShards rows
+// are derived from a Vandermonde matrix. Encoding multiplies this
+// matrix against the data shards; reconstruction inverts the
+// submatrix formed by any dataShards surviving rows and multiplies it
+// against their shards.
+type ReedSolomon struct {
+	dataShards, parityShards int
+	matrix                   gfMatrix
+}
+
+// NewReedSolomon returns a codec that splits a payload into dataShards
+// shards and produces parityShards parity shards alongside them.
+func NewReedSolomon(dataShards, parityShards int) (*ReedSolomon, error) {
+	if dataShards <= 0 || parityShards <= 0 {
+		return nil, fmt.Errorf("erasure: dataShards and parityShards must be positive")
+	}
+	if dataShards+parityShards > 255 {
+		return nil, fmt.Errorf("erasure: dataShards+parityShards must not exceed 255")
+	}
+
+	matrix, err := buildEncodingMatrix(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReedSolomon{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       matrix,
+	}, nil
+}
+
+// buildEncodingMatrix returns a (dataShards+parityShards) x dataShards
+// matrix whose every dataShards-row submatrix is invertible, which is
+// what lets Decode reconstruct the original data from any dataShards
+// surviving shards.
+//
+// A plain Vandermonde matrix has that property for any subset of its
+// rows, since each row corresponds to a distinct evaluation point. But
+// stacking an identity matrix directly on top of a separately built
+// Vandermonde matrix does not: the identity rows aren't evaluation
+// points of the same Vandermonde family, so a submatrix mixing rows
+// from both halves can come out singular. Instead, build one
+// (dataShards+parityShards)-row Vandermonde matrix over dataShards+
+// parityShards distinct points, then multiply it by the inverse of its
+// own top dataShards rows. That turns the top into the identity while
+// keeping every row a linear combination of genuine Vandermonde rows,
+// so the any-dataShards-rows-invertible property carries over to the
+// whole matrix.
+func buildEncodingMatrix(dataShards, parityShards int) (gfMatrix, error) {
+	vandermonde := gfVandermonde(dataShards+parityShards, dataShards)
+	top := vandermonde[:dataShards]
+	topInv, err := top.invert()
+	if err != nil {
+		return nil, err
+	}
+	return vandermonde.multiply(topInv), nil
+}
+
+// Encode splits data into rs.dataShards shards and appends
+// rs.parityShards parity shards, returning all of them as a single
+// slice indexed 0..dataShards+parityShards-1. Data shards may be
+// shorter than their neighbours on the last shard; all are padded with
+// zeroes to a common shard length before parity is computed.
+//
+// ReedSolomon does not special-case (2, 1) to reuse the package's
+// original XOR encoder: that scheme's blocks carry their own header,
+// Algorithm and checksum, a format raw Reed-Solomon shards don't have,
+// so mixing the two would give (2, 1) a different shard format and a
+// different empty-input behaviour than every other (dataShards,
+// parityShards) pair. Use Encode/EncodeWith directly for the 2-of-3
+// XOR scheme's checksummed blocks.
+func (rs *ReedSolomon) Encode(data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("erasure: cannot encode empty data")
+	}
+
+	shardLen := (len(data) + rs.dataShards - 1) / rs.dataShards
+	dataMatrix := newGFMatrix(rs.dataShards, shardLen)
+	for i := 0; i < rs.dataShards; i++ {
+		start := i * shardLen
+		end := start + shardLen
+		if start > len(data) {
+			start = len(data)
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		copy(dataMatrix[i], data[start:end])
+	}
+
+	parity := rs.matrix[rs.dataShards:].multiply(dataMatrix)
+
+	shards := make([][]byte, rs.dataShards+rs.parityShards)
+	copy(shards, dataMatrix)
+	copy(shards[rs.dataShards:], parity)
+	return shards, nil
+}
+
+// Decode reconstructs the original payload from shards, a slice of
+// exactly rs.dataShards+rs.parityShards entries indexed the same way
+// Encode returned them; missing shards must be represented as nil. At
+// least rs.dataShards of the entries must be non-nil.
+//
+// The returned payload is padded to a multiple of rs.dataShards with
+// trailing zeroes; callers that need the exact original length should
+// track it themselves.
+func (rs *ReedSolomon) Decode(shards [][]byte) ([]byte, error) {
+	total := rs.dataShards + rs.parityShards
+	if len(shards) != total {
+		return nil, fmt.Errorf("erasure: expected %d shards, got %d", total, len(shards))
+	}
+
+	present := make([]int, 0, rs.dataShards)
+	var shardLen int
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+		if shardLen == 0 {
+			shardLen = len(s)
+		} else if len(s) != shardLen {
+			return nil, fmt.Errorf("erasure: shard %d has length %d, want %d", i, len(s), shardLen)
+		}
+		present = append(present, i)
+		if len(present) == rs.dataShards {
+			break
+		}
+	}
+	if len(present) < rs.dataShards {
+		return nil, fmt.Errorf("erasure: need %d surviving shards, have %d", rs.dataShards, len(present))
+	}
+
+	sub := rs.matrix.subMatrixRows(present)
+	inv, err := sub.invert()
+	if err != nil {
+		return nil, err
+	}
+
+	surviving := newGFMatrix(rs.dataShards, shardLen)
+	for i, idx := range present {
+		surviving[i] = shards[idx]
+	}
+
+	dataMatrix := inv.multiply(surviving)
+	data := make([]byte, 0, rs.dataShards*shardLen)
+	for _, row := range dataMatrix {
+		data = append(data, row...)
+	}
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package erasure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewReedSolomonRejectsBadShardCounts(t *testing.T) {
+	if _, err := NewReedSolomon(0, 1); err == nil {
+		t.Errorf("want error for 0 data shards")
+	}
+	if _, err := NewReedSolomon(1, 0); err == nil {
+		t.Errorf("want error for 0 parity shards")
+	}
+	if _, err := NewReedSolomon(200, 100); err == nil {
+		t.Errorf("want error for more than 255 total shards")
+	}
+}
+
+func TestReedSolomonEncodeRejectsEmptyData(t *testing.T) {
+	// (2, 1) must behave the same as every other (dataShards,
+	// parityShards) pair: it has no special-cased shard format of its
+	// own, so it has no special-cased empty-input behaviour either.
+	for _, tt := range []struct{ dataShards, parityShards int }{
+		{2, 1},
+		{3, 1},
+	} {
+		rs, err := NewReedSolomon(tt.dataShards, tt.parityShards)
+		if err != nil {
+			t.Fatalf("(%d,%d): %v", tt.dataShards, tt.parityShards, err)
+		}
+		if _, err := rs.Encode(nil); err == nil {
+			t.Errorf("(%d,%d): want error encoding empty data", tt.dataShards, tt.parityShards)
+		}
+	}
+}
+
+func TestReedSolomonEncodeDecode(t *testing.T) {
+	tests := []struct {
+		dataShards, parityShards int
+	}{
+		{2, 1},
+		{3, 2},
+		{4, 1},
+		{5, 3},
+	}
+
+	data := nBytes(1 << 12)
+	for _, tt := range tests {
+		rs, err := NewReedSolomon(tt.dataShards, tt.parityShards)
+		if err != nil {
+			t.Fatalf("(%d,%d): %v", tt.dataShards, tt.parityShards, err)
+		}
+
+		shards, err := rs.Encode(data)
+		if err != nil {
+			t.Fatalf("(%d,%d): encode: %v", tt.dataShards, tt.parityShards, err)
+		}
+		if len(shards) != tt.dataShards+tt.parityShards {
+			t.Fatalf("(%d,%d): got %d shards, want %d", tt.dataShards, tt.parityShards, len(shards), tt.dataShards+tt.parityShards)
+		}
+
+		// drop the first 1 up to parityShards shards and make sure
+		// reconstruction still works from the ones that survive.
+		for dropped := 0; dropped < tt.parityShards; dropped++ {
+			surviving := make([][]byte, len(shards))
+			copy(surviving, shards)
+			for i := 0; i <= dropped; i++ {
+				surviving[i] = nil
+			}
+
+			got, err := rs.Decode(surviving)
+			if err != nil {
+				t.Fatalf("(%d,%d) dropped=%d: decode: %v", tt.dataShards, tt.parityShards, dropped, err)
+			}
+			if !bytes.Equal(got[:len(data)], data) {
+				t.Errorf("(%d,%d) dropped=%d: reconstructed data does not match original", tt.dataShards, tt.parityShards, dropped)
+			}
+		}
+	}
+}
+
+func TestReedSolomonDecodeNotEnoughShards(t *testing.T) {
+	rs, err := NewReedSolomon(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := rs.Encode(nBytes(1 << 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards[0], shards[1], shards[2] = nil, nil, nil
+
+	if _, err := rs.Decode(shards); err == nil {
+		t.Errorf("should have gotten an error decoding with too many missing shards")
+	}
+}
@@ -0,0 +1,319 @@
+package erasure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// chunkSize is the amount of payload data framed into a single set of
+// blocks by NewEncoder/NewDecoder, chosen so large payloads can be
+// processed without materializing more than a couple of copies in RAM.
+const chunkSize = 1 << 20 // 1 MiB
+
+// frameMagic marks the start of a frame. A corrupted length field
+// leaves a reader with no way to know where the next frame begins;
+// the magic marker is what lets readFrame resynchronize with the
+// stream by scanning forward for it, instead of either trusting a
+// corrupted length or giving up on the rest of the shard.
+var frameMagic = [4]byte{'e', 'r', 'b', 'k'}
+
+// maxFrameBlockLen bounds the block length a frame header is allowed
+// to declare. Real frames never carry more than a chunkSize payload
+// plus a small constant for the block's own header and checksum; a
+// length beyond that can only be a corrupted or forged header, and
+// must be rejected before it's used to size an allocation.
+const maxFrameBlockLen = chunkSize + 1024
+
+// frameHeaderLen is the size of a frame's header: a 4-byte magic
+// marker, an 8-byte sequence number, a 4-byte block length, a 4-byte
+// CRC32 of the magic+seq+length that precede it, and a 4-byte CRC32
+// of the block that follows. The header checksum guards the header
+// itself - independently of the block checksum - so a corrupted seq
+// or length is caught before it's trusted for anything, including
+// finding the next frame.
+const frameHeaderLen = 4 + 8 + 4 + 4 + 4
+
+// writeFrame writes one length-and-checksum-framed block to w.
+func writeFrame(w io.Writer, seq uint64, block []byte) error {
+	header := make([]byte, frameHeaderLen)
+	copy(header[0:4], frameMagic[:])
+	binary.BigEndian.PutUint64(header[4:12], seq)
+	binary.BigEndian.PutUint32(header[12:16], uint32(len(block)))
+	binary.BigEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header[0:16]))
+	binary.BigEndian.PutUint32(header[20:24], crc32.ChecksumIEEE(block))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(block)
+	return err
+}
+
+// validHeader reports whether header (frameHeaderLen bytes) starts
+// with frameMagic, its own checksum matches, and it declares a
+// plausible block length. It does not check the block CRC; that's
+// verified once the block itself has been read.
+func validHeader(header []byte) bool {
+	if !bytes.Equal(header[0:4], frameMagic[:]) {
+		return false
+	}
+	if crc32.ChecksumIEEE(header[0:16]) != binary.BigEndian.Uint32(header[16:20]) {
+		return false
+	}
+	n := binary.BigEndian.Uint32(header[12:16])
+	return n <= maxFrameBlockLen
+}
+
+// resync consumes bytes from r, treating the tail of header as the
+// start of the search, until the next frameMagic sequence is found.
+// It leaves r positioned right after that marker. Call it when a
+// frame's header fails validation: the length it declared can no
+// longer be trusted to find the next frame, so the only way back to a
+// known-good position is to scan for the marker that starts one.
+func resync(r io.Reader, header []byte) error {
+	window := append([]byte(nil), header[len(header)-min(len(header), len(frameMagic)-1):]...)
+	one := make([]byte, 1)
+	for !bytes.Equal(window, frameMagic[:]) {
+		if _, err := io.ReadFull(r, one); err != nil {
+			return err
+		}
+		window = append(window, one[0])
+		if len(window) > len(frameMagic) {
+			window = window[len(window)-len(frameMagic):]
+		}
+	}
+	return nil
+}
+
+// readFrame reads one frame from r. ok is false when the frame's
+// header was valid but its block CRC does not match its payload,
+// which a caller should treat the same as a missing shard for this
+// chunk rather than a hard read error.
+//
+// If a frame's header is corrupted, readFrame resynchronizes with the
+// stream and keeps reading until it finds a valid one (or runs out of
+// stream), so one damaged frame costs only the chunk it belonged to
+// rather than desynchronizing every frame read afterwards.
+func readFrame(r io.Reader) (seq uint64, block []byte, ok bool, err error) {
+	header := make([]byte, frameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, false, err
+	}
+
+	for !validHeader(header) {
+		if err := resync(r, header); err != nil {
+			return 0, nil, false, err
+		}
+		copy(header, frameMagic[:])
+		if _, err := io.ReadFull(r, header[len(frameMagic):]); err != nil {
+			return 0, nil, false, err
+		}
+	}
+
+	seq = binary.BigEndian.Uint64(header[4:12])
+	n := binary.BigEndian.Uint32(header[12:16])
+	wantCRC := binary.BigEndian.Uint32(header[20:24])
+
+	block = make([]byte, n)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return seq, nil, false, err
+	}
+	return seq, block, crc32.ChecksumIEEE(block) == wantCRC, nil
+}
+
+// NewEncoder returns a WriteCloser that buffers writes into chunkSize
+// chunks, encodes each chunk with EncodeWith(chunk, opts), and writes
+// one framed block per destination writer in dsts. dsts must have
+// exactly 3 entries, matching Encode's 2-of-3 scheme.
+//
+// This lets large payloads be erasure-coded without holding the whole
+// payload and all 3 of its encoded copies in memory at once, the way
+// Encode does.
+//
+// Close must be called to flush any buffered remainder smaller than a
+// full chunk, and the returned WriteCloser is not safe to use from
+// multiple goroutines or to Close twice.
+func NewEncoder(dsts []io.Writer, opts Options) (io.WriteCloser, error) {
+	if len(dsts) != 3 {
+		return nil, fmt.Errorf("erasure: NewEncoder needs exactly 3 destination writers, got %d", len(dsts))
+	}
+	return &streamEncoder{dsts: dsts, opts: opts}, nil
+}
+
+type streamEncoder struct {
+	dsts []io.Writer
+	opts Options
+	buf  []byte
+	seq  uint64
+}
+
+func (e *streamEncoder) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) >= chunkSize {
+		if err := e.encodeChunk(e.buf[:chunkSize]); err != nil {
+			return len(p), err
+		}
+		e.buf = e.buf[chunkSize:]
+	}
+	return len(p), nil
+}
+
+func (e *streamEncoder) Close() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+	err := e.encodeChunk(e.buf)
+	e.buf = nil
+	return err
+}
+
+func (e *streamEncoder) encodeChunk(chunk []byte) error {
+	a, b, x, err := EncodeWith(chunk, e.opts)
+	if err != nil {
+		return err
+	}
+	blocks := [3][]byte{a, b, x}
+	for i, dst := range e.dsts {
+		if err := writeFrame(dst, e.seq, blocks[i]); err != nil {
+			return fmt.Errorf("erasure: writing chunk %d to shard %d: %w", e.seq, i, err)
+		}
+	}
+	e.seq++
+	return nil
+}
+
+// NewDecoder returns a Reader that reads framed blocks from srcs, in
+// the same order NewEncoder's dsts were given, and streams out the
+// reconstructed payload one decoded chunk at a time. srcs must have
+// exactly 3 entries; as with Decode, any 2 of the 3 shards need to
+// produce a valid frame for a given chunk to be recovered, so the
+// stream can survive one shard being truncated or corrupted.
+//
+// Each block records its own Algorithm, so NewDecoder does not need to
+// be told which one NewEncoder used.
+func NewDecoder(srcs []io.Reader) (io.Reader, error) {
+	if len(srcs) != 3 {
+		return nil, fmt.Errorf("erasure: NewDecoder needs exactly 3 source readers, got %d", len(srcs))
+	}
+	return &streamDecoder{srcs: srcs}, nil
+}
+
+// pendingFrame is one shard's lookahead: a frame that's been read off
+// the wire but not yet consumed because it belongs to a chunk other
+// than the one currently being assembled.
+type pendingFrame struct {
+	seq   uint64
+	block []byte
+	ok    bool
+}
+
+type streamDecoder struct {
+	srcs    []io.Reader
+	buf     []byte
+	seq     uint64
+	pending [3]*pendingFrame
+	done    [3]bool // shard hit EOF or a hard read error; nothing more to offer
+}
+
+func (d *streamDecoder) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if err := d.decodeChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// frameForCurrentChunk returns shard i's frame for the chunk d is
+// currently assembling (d.seq), or nil if that shard has none right
+// now. It keeps one frame of lookahead per shard: if an earlier
+// corrupted frame forced readFrame to resynchronize past more than
+// one frame's worth of the stream, that shard's next real frame may
+// carry a seq ahead of d.seq. Rather than treating the shard as gone
+// for good, the frame is cached in d.pending until d.seq catches up
+// to it - so a multi-frame desync on one shard costs only the chunks
+// actually lost, not every chunk decoded afterwards.
+func (d *streamDecoder) frameForCurrentChunk(i int) (*pendingFrame, error) {
+	if d.done[i] {
+		return nil, nil
+	}
+	if d.pending[i] == nil {
+		seq, block, ok, err := readFrame(d.srcs[i])
+		if err != nil {
+			d.done[i] = true
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		d.pending[i] = &pendingFrame{seq: seq, block: block, ok: ok}
+	}
+
+	switch pf := d.pending[i]; {
+	case pf.seq < d.seq:
+		// stale: left over from resynchronizing past a chunk this shard
+		// no longer has a frame for. Drop it and look at the next one.
+		d.pending[i] = nil
+		return d.frameForCurrentChunk(i)
+	case pf.seq > d.seq:
+		// this shard is ahead of the chunk being assembled; keep it
+		// buffered until d.seq reaches it.
+		return nil, nil
+	default:
+		d.pending[i] = nil
+		return pf, nil
+	}
+}
+
+func (d *streamDecoder) decodeChunk() error {
+	var blocks [3][]byte
+	present, chunkLen := 0, 0
+	var firstErr error
+
+	for i := range d.srcs {
+		pf, err := d.frameForCurrentChunk(i)
+		switch {
+		case err != nil:
+			if firstErr == nil {
+				firstErr = err
+			}
+		case pf == nil:
+			// nothing for this chunk from this shard: EOF, a hard read
+			// error already recorded earlier, or it's run ahead of us
+		case !pf.ok:
+			// corrupted frame: treat this shard as missing for this chunk
+		default:
+			blocks[i] = pf.block
+			chunkLen = len(pf.block)
+			present++
+		}
+	}
+
+	if present == 0 {
+		if firstErr != nil {
+			return firstErr
+		}
+		return io.EOF
+	}
+	if present < 2 {
+		return fmt.Errorf("erasure: only %d of 3 shards readable for chunk %d, need at least 2", present, d.seq)
+	}
+
+	for i := range blocks {
+		if blocks[i] == nil {
+			blocks[i] = make([]byte, chunkLen)
+		}
+	}
+
+	data, _, err := Decode(blocks[0], blocks[1], blocks[2])
+	if err != nil {
+		return err
+	}
+	d.buf = data
+	d.seq++
+	return nil
+}
@@ -0,0 +1,114 @@
+package erasure
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStreamEncodeDecode(t *testing.T) {
+	data := nBytes(int(chunkSize*2 + 137)) // a couple full chunks plus a remainder
+
+	var bufA, bufB, bufX bytes.Buffer
+	enc, err := NewEncoder([]io.Writer{&bufA, &bufB, &bufX}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := NewDecoder([]io.Reader{&bufA, &bufB, &bufX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decoded stream does not match original payload")
+	}
+}
+
+func TestStreamDecodeSurvivesOneMissingShard(t *testing.T) {
+	data := nBytes(int(chunkSize + 42))
+
+	var bufA, bufB, bufX bytes.Buffer
+	enc, err := NewEncoder([]io.Writer{&bufA, &bufB, &bufX}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// shard X is gone entirely; A and B alone must still be enough.
+	dec, err := NewDecoder([]io.Reader{&bufA, &bufB, bytes.NewReader(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decoded stream does not match original payload")
+	}
+}
+
+func TestStreamRecoversFromOneCorruptedFrame(t *testing.T) {
+	data := nBytes(int(chunkSize*3 + 7)) // a few chunks, so there's a "rest of the stream" to desync
+
+	var bufA, bufB, bufX bytes.Buffer
+	enc, err := NewEncoder([]io.Writer{&bufA, &bufB, &bufX}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the length field of B's very first frame header. A
+	// corrupted length can't be trusted to find the next frame, so this
+	// must cost only the first chunk, not every frame read from B
+	// afterwards.
+	corruptB := bufB.Bytes()
+	corruptB[13] ^= 0x01
+
+	dec, err := NewDecoder([]io.Reader{&bufA, bytes.NewReader(corruptB), &bufX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decoded stream does not match original payload after one corrupted frame")
+	}
+}
+
+func TestNewEncoderRejectsWrongShardCount(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewEncoder([]io.Writer{&buf, &buf}, Options{}); err == nil {
+		t.Errorf("want error for 2 destination writers")
+	}
+}
+
+func TestNewDecoderRejectsWrongShardCount(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewDecoder([]io.Reader{&buf, &buf}); err == nil {
+		t.Errorf("want error for 2 source readers")
+	}
+}